@@ -0,0 +1,186 @@
+// Package credentials 提供可插拔的仓库登录凭证解析，取代过去单一的
+// RegistryUserName/RegistryPassword 字段：依次尝试 rules.yaml 里配置的静态账号密码、
+// ~/.docker/config.json(auths/credsStore/credHelpers)，以及云厂商 CLI 换取的短时
+// token(gcloud/aws/az)，这样 CI 里不用把密码当作命令行参数传入就能登录私有仓库。
+package credentials
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Resolver 解析某个 registry 对应的登录凭证
+type Resolver interface {
+	AuthFor(registry string) (types.AuthConfig, error)
+}
+
+// ChainResolver 依次尝试一组 Resolver，返回第一个成功解析出凭证的结果，
+// 全部失败时返回最后一个 Resolver 的报错
+type ChainResolver []Resolver
+
+func (c ChainResolver) AuthFor(registry string) (types.AuthConfig, error) {
+	var lastErr error
+	for _, r := range c {
+		auth, err := r.AuthFor(registry)
+		if err == nil {
+			return auth, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("credentials: 没有配置任何凭证解析器")
+	}
+	return types.AuthConfig{}, lastErr
+}
+
+// StaticResolver 是固定账号密码的兜底解析器，对应 rules.yaml/命令行里直接
+// 配置的 registry_user_name/registry_password
+type StaticResolver struct {
+	Registry string
+	Username string
+	Password string
+}
+
+func (s StaticResolver) AuthFor(registry string) (types.AuthConfig, error) {
+	if s.Username == "" || registry != s.Registry {
+		return types.AuthConfig{}, fmt.Errorf("credentials: 没有为 %s 配置静态账号密码", registry)
+	}
+	return types.AuthConfig{Username: s.Username, Password: s.Password, ServerAddress: s.Registry}, nil
+}
+
+// dockerConfig 对应 ~/.docker/config.json 里我们关心的几个字段
+type dockerConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type dockerConfigAuth struct {
+	Auth string `json:"auth"`
+}
+
+// DockerConfigResolver 从 ~/.docker/config.json 读取 auths/credsStore/credHelpers，
+// 行为与 `docker login` 写入、`docker pull` 读取的方式保持一致
+type DockerConfigResolver struct {
+	Path string
+}
+
+// NewDockerConfigResolver 用当前用户默认的 ~/.docker/config.json 构造一个 DockerConfigResolver
+func NewDockerConfigResolver() DockerConfigResolver {
+	home, _ := os.UserHomeDir()
+	return DockerConfigResolver{Path: filepath.Join(home, ".docker", "config.json")}
+}
+
+func (d DockerConfigResolver) load() (dockerConfig, error) {
+	var cfg dockerConfig
+	raw, err := os.ReadFile(d.Path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func (d DockerConfigResolver) AuthFor(registry string) (types.AuthConfig, error) {
+	cfg, err := d.load()
+	if err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	if helper, ok := cfg.CredHelpers[registry]; ok {
+		return runCredHelper(helper, registry)
+	}
+	if entry, ok := cfg.Auths[registry]; ok && entry.Auth != "" {
+		return decodeBasicAuth(registry, entry.Auth)
+	}
+	if cfg.CredsStore != "" {
+		return runCredHelper(cfg.CredsStore, registry)
+	}
+	return types.AuthConfig{}, fmt.Errorf("credentials: %s 在 %s 里没有找到凭证", registry, d.Path)
+}
+
+func decodeBasicAuth(registry, encoded string) (types.AuthConfig, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return types.AuthConfig{}, err
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return types.AuthConfig{}, errors.New("credentials: auth 字段格式不对")
+	}
+	return types.AuthConfig{Username: parts[0], Password: parts[1], ServerAddress: registry}, nil
+}
+
+// runCredHelper 调用 docker-credential-<helper> get，协议与 docker 自带的
+// credential helper 一致：registry 从 stdin 传入，stdout 返回一段 JSON
+func runCredHelper(helper, registry string) (types.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return types.AuthConfig{}, fmt.Errorf("credentials: 调用 docker-credential-%s 报错: %w", helper, err)
+	}
+	var resp struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return types.AuthConfig{}, err
+	}
+	return types.AuthConfig{Username: resp.Username, Password: resp.Secret, ServerAddress: registry}, nil
+}
+
+// CloudResolver 通过已安装的云厂商 CLI 换取短时 token：GCR/Artifact Registry 走
+// `gcloud auth print-access-token`(配合固定用户名 oauth2accesstoken)，ECR 走
+// `aws ecr get-login-password`，ACR 走 `az acr login --expose-token`
+type CloudResolver struct{}
+
+func (CloudResolver) AuthFor(registry string) (types.AuthConfig, error) {
+	switch {
+	case strings.HasSuffix(registry, "gcr.io") || strings.Contains(registry, "-docker.pkg.dev"):
+		token, err := runCLI("gcloud", "auth", "print-access-token")
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		return types.AuthConfig{Username: "oauth2accesstoken", Password: token, ServerAddress: registry}, nil
+
+	case strings.Contains(registry, ".dkr.ecr.") && strings.HasSuffix(registry, ".amazonaws.com"):
+		token, err := runCLI("aws", "ecr", "get-login-password")
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		return types.AuthConfig{Username: "AWS", Password: token, ServerAddress: registry}, nil
+
+	case strings.HasSuffix(registry, ".azurecr.io"):
+		name := strings.TrimSuffix(registry, ".azurecr.io")
+		token, err := runCLI("az", "acr", "login", "--name", name, "--expose-token", "--output", "tsv", "--query", "accessToken")
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		return types.AuthConfig{Username: "00000000-0000-0000-0000-000000000000", Password: token, ServerAddress: registry}, nil
+	}
+	return types.AuthConfig{}, fmt.Errorf("credentials: %s 不是已知的云厂商 registry", registry)
+}
+
+func runCLI(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("credentials: 调用 %s 报错: %w", name, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,263 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestDecodeBasicAuth(t *testing.T) {
+	cases := []struct {
+		name     string
+		registry string
+		encoded  string
+		want     types.AuthConfig
+		wantErr  bool
+	}{
+		{
+			name:     "valid user:pass",
+			registry: "registry-1.docker.io",
+			encoded:  base64.StdEncoding.EncodeToString([]byte("alice:hunter2")),
+			want:     types.AuthConfig{Username: "alice", Password: "hunter2", ServerAddress: "registry-1.docker.io"},
+		},
+		{
+			name:     "password containing colon",
+			registry: "gcr.io",
+			encoded:  base64.StdEncoding.EncodeToString([]byte("bob:p:a:s:s")),
+			want:     types.AuthConfig{Username: "bob", Password: "p:a:s:s", ServerAddress: "gcr.io"},
+		},
+		{
+			name:    "not base64",
+			encoded: "not-base64!!!",
+			wantErr: true,
+		},
+		{
+			name:    "missing colon separator",
+			encoded: base64.StdEncoding.EncodeToString([]byte("no-colon-here")),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeBasicAuth(tc.registry, tc.encoded)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("decodeBasicAuth(%q) expected error, got %+v", tc.encoded, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeBasicAuth(%q) unexpected error: %v", tc.encoded, err)
+			}
+			if got != tc.want {
+				t.Fatalf("decodeBasicAuth(%q) = %+v, want %+v", tc.encoded, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStaticResolverAuthFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		resolver StaticResolver
+		registry string
+		want     types.AuthConfig
+		wantErr  bool
+	}{
+		{
+			name:     "matching registry",
+			resolver: StaticResolver{Registry: "gcr.io", Username: "alice", Password: "hunter2"},
+			registry: "gcr.io",
+			want:     types.AuthConfig{Username: "alice", Password: "hunter2", ServerAddress: "gcr.io"},
+		},
+		{
+			name:     "registry mismatch",
+			resolver: StaticResolver{Registry: "gcr.io", Username: "alice", Password: "hunter2"},
+			registry: "ghcr.io",
+			wantErr:  true,
+		},
+		{
+			name:     "no username configured",
+			resolver: StaticResolver{Registry: "gcr.io"},
+			registry: "gcr.io",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.resolver.AuthFor(tc.registry)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("AuthFor(%q) expected error, got %+v", tc.registry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthFor(%q) unexpected error: %v", tc.registry, err)
+			}
+			if got != tc.want {
+				t.Fatalf("AuthFor(%q) = %+v, want %+v", tc.registry, got, tc.want)
+			}
+		})
+	}
+}
+
+type stubResolver struct {
+	auth types.AuthConfig
+	err  error
+}
+
+func (s stubResolver) AuthFor(string) (types.AuthConfig, error) {
+	return s.auth, s.err
+}
+
+func TestChainResolverAuthFor(t *testing.T) {
+	ok := types.AuthConfig{Username: "alice"}
+	errA := errors.New("resolver a failed")
+	errB := errors.New("resolver b failed")
+
+	cases := []struct {
+		name    string
+		chain   ChainResolver
+		want    types.AuthConfig
+		wantErr error
+	}{
+		{
+			name:  "first resolver succeeds",
+			chain: ChainResolver{stubResolver{auth: ok}, stubResolver{err: errB}},
+			want:  ok,
+		},
+		{
+			name:  "falls through to second resolver",
+			chain: ChainResolver{stubResolver{err: errA}, stubResolver{auth: ok}},
+			want:  ok,
+		},
+		{
+			name:    "all resolvers fail, returns last error",
+			chain:   ChainResolver{stubResolver{err: errA}, stubResolver{err: errB}},
+			wantErr: errB,
+		},
+		{
+			name:    "empty chain",
+			chain:   ChainResolver{},
+			wantErr: nil, // any non-nil error is acceptable, checked below
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.chain.AuthFor("gcr.io")
+			if tc.name == "empty chain" {
+				if err == nil {
+					t.Fatalf("AuthFor() on empty chain expected error, got %+v", got)
+				}
+				return
+			}
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("AuthFor() err = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthFor() unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("AuthFor() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// writeCredHelper 在 dir 下生成一个可执行的 docker-credential-<name>，
+// 不读取 stdin 里的 registry 名字，总是返回同一段固定的 JSON 响应
+func writeCredHelper(t *testing.T, dir, name string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("docker-credential-<helper> 协议约定的是 *nix 可执行脚本")
+	}
+	script := "#!/bin/sh\ncat <<'EOF'\n{\"Username\":\"helper-user\",\"Secret\":\"helper-pass\"}\nEOF\n"
+	path := filepath.Join(dir, "docker-credential-"+name)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("写入 fake credential helper 失败: %v", err)
+	}
+}
+
+func TestDockerConfigResolverAuthFor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("docker-credential-<helper> 协议约定的是 *nix 可执行脚本")
+	}
+
+	helperDir := t.TempDir()
+	writeCredHelper(t, helperDir, "fake")
+	t.Setenv("PATH", helperDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	authEncoded := base64.StdEncoding.EncodeToString([]byte("inline-user:inline-pass"))
+
+	cases := []struct {
+		name   string
+		config string
+		want   types.AuthConfig
+	}{
+		{
+			name: "credHelpers takes precedence over auths",
+			config: `{
+				"auths": {"gcr.io": {"auth": "` + authEncoded + `"}},
+				"credHelpers": {"gcr.io": "fake"}
+			}`,
+			want: types.AuthConfig{Username: "helper-user", Password: "helper-pass", ServerAddress: "gcr.io"},
+		},
+		{
+			name:   "falls back to auths entry",
+			config: `{"auths": {"gcr.io": {"auth": "` + authEncoded + `"}}}`,
+			want:   types.AuthConfig{Username: "inline-user", Password: "inline-pass", ServerAddress: "gcr.io"},
+		},
+		{
+			name:   "falls back to credsStore when nothing else matches",
+			config: `{"credsStore": "fake"}`,
+			want:   types.AuthConfig{Username: "helper-user", Password: "helper-pass", ServerAddress: "gcr.io"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tc.config), 0o644); err != nil {
+				t.Fatalf("写入临时 config.json 失败: %v", err)
+			}
+			resolver := DockerConfigResolver{Path: configPath}
+			got, err := resolver.AuthFor("gcr.io")
+			if err != nil {
+				t.Fatalf("AuthFor(%q) unexpected error: %v", "gcr.io", err)
+			}
+			if got != tc.want {
+				t.Fatalf("AuthFor(%q) = %+v, want %+v", "gcr.io", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("no entry for registry", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(configPath, []byte(`{"auths": {}}`), 0o644); err != nil {
+			t.Fatalf("写入临时 config.json 失败: %v", err)
+		}
+		resolver := DockerConfigResolver{Path: configPath}
+		if _, err := resolver.AuthFor("gcr.io"); err == nil {
+			t.Fatalf("AuthFor(gcr.io) expected error for empty config")
+		}
+	})
+
+	t.Run("config file missing", func(t *testing.T) {
+		resolver := DockerConfigResolver{Path: filepath.Join(t.TempDir(), "does-not-exist.json")}
+		if _, err := resolver.AuthFor("gcr.io"); err == nil {
+			t.Fatalf("AuthFor(gcr.io) expected error when config file is missing")
+		}
+	})
+}
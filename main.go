@@ -11,11 +11,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"text/template"
 
+	"github.com/MainPoser/gcr.io_mirror/credentials"
+	"github.com/MainPoser/gcr.io_mirror/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/google/go-github/v47/github"
@@ -30,56 +31,121 @@ const RulesFile = "rules.yaml"
 var (
 	config    = &Config{}
 	resultTpl = `
+{{ if .Tags }}
+{{ if .Success }}
+**批量转换完成，共 {{ len .Tags }} 个 tag**
+{{ else if .Partial }}
+**批量转换部分完成，共 {{ len .Tags }} 个 tag**
+{{ else }}
+**批量转换失败，共 {{ len .Tags }} 个 tag**
+{{ end }}
+
+| Tag | 目标仓库 | 目标镜像 | 结果 | 签名/SBOM |
+| --- | --- | --- | --- | --- |
+{{ range .Tags }}{{ $tag := .Tag }}{{ if .Destinations }}{{ range .Destinations }}| {{ $tag }} | {{ .Registry }} | {{ .TargetImageName }} | {{ if .Success }}成功{{ else }}失败: {{ .Error }}{{ end }} | {{ if .Artifacts }}{{ range $i, $a := .Artifacts }}{{ if $i }}, {{ end }}{{ $a }}{{ end }}{{ else }}-{{ end }} |
+{{ end }}{{ else }}| {{ $tag }} | - | - | 失败: {{ .Error }} | - |
+{{ end }}{{ end }}
+{{ else }}
 {{ if .Success }}
 **转换完成**
-^^^bash
-{{ if .Registry }}
-docker login -u{{ .RegistryUser }} {{ .Registry }}
+
+| 目标仓库 | 目标镜像 | 签名/SBOM |
+| --- | --- | --- |
+{{ range .Destinations }}| {{ .Registry }} | {{ .TargetImageName }} | {{ if .Artifacts }}{{ range $i, $a := .Artifacts }}{{ if $i }}, {{ end }}{{ $a }}{{ end }}{{ else }}-{{ end }} |
 {{ end }}
+^^^bash
 #原镜像
 {{ .OriginImageName }}
 
-#转换后镜像
-{{ .TargetImageName }}
-
-
-#下载并重命名镜像
+{{ range .Destinations }}
+#下载并重命名镜像（来自 {{ .Registry }}）
 docker pull {{ .TargetImageName }}
 
-docker tag  {{ .TargetImageName }} {{ .OriginImageName }}
-
-docker images | grep $(echo {{ .OriginImageName }} |awk -F':' '{print $1}')
-
+docker tag  {{ .TargetImageName }} {{ $.OriginImageName }}
+{{ end }}
 ^^^
 {{ else }}
 **转换失败**
 详见 [构建任务](https://github.com/{{ .GhUser }}/{{ .Repo }}/actions/runs/{{ .RunId }})
+{{ range .Destinations }}{{ if not .Success }}
+- {{ .Registry }}: {{ .Error }}
+{{ end }}{{ end }}
+{{ end }}
 {{ end }}
 `
 )
 
 // Config 用来记录程序执行的配置信息
 type Config struct {
-	GhToken           string            `yaml:"gh_token"`
-	GhUser            string            `yaml:"gh_user"`
-	Repo              string            `yaml:"repo"`
-	Registry          string            `yaml:"registry"`
-	RegistryNamespace string            `yaml:"registry_namespace"`
-	RegistryUserName  string            `yaml:"registry_user_name"`
-	RegistryPassword  string            `yaml:"registry_password"`
-	Rules             map[string]string `yaml:"rules"`
-	RunId             string            `yaml:"run_id"`
-	MaxCount          int               `yaml:"max_count"`
-	RulesFile         string            `yaml:"rules_file"`
+	GhToken   string `yaml:"gh_token"`
+	GhUser    string `yaml:"gh_user"`
+	Repo      string `yaml:"repo"`
+	Rules     []Rule `yaml:"rules"`
+	RunId     string `yaml:"run_id"`
+	MaxCount  int    `yaml:"max_count"`
+	RulesFile string `yaml:"rules_file"`
+	Mode      string `yaml:"mode"`
+	// SyncParallel 控制 all-tags 模式下同时搬运的 tag 数量
+	SyncParallel int `yaml:"sync_parallel"`
+	// CopySignatures 控制是否一并搬运 cosign 签名/attestation/SBOM 这几个 sibling tag
+	CopySignatures bool `yaml:"copy_signatures"`
+}
+
+// LabelPorterAll 是触发“搬运整个仓库所有 tag”的 issue 标题前缀，
+// 等价于 [PORTER] 标题里把 tag 写成 "*"
+const LabelPorterAll = "[PORTER-ALL]"
+
+// Destination 描述一个搬运目标：目标仓库地址 + 命名空间 + 专属账号密码。
+// RegistryUserName/RegistryPassword 只是 resolverFor 凭证解析链里最靠前的一级
+// (StaticResolver)，没有配置时会依次退回到 ~/.docker/config.json 和云厂商 CLI
+type Destination struct {
+	Registry          string `yaml:"registry"`
+	RegistryNamespace string `yaml:"registry_namespace"`
+	RegistryUserName  string `yaml:"registry_user_name"`
+	RegistryPassword  string `yaml:"registry_password"`
+}
+
+// Rule 是一条镜像改写规则：Pattern 命中源镜像的 domain 后，用 Replacement
+// 改写仓库前缀，再把结果同时推送到 Destinations 里的每一个目标仓库
+type Rule struct {
+	Pattern      string        `yaml:"pattern"`
+	Replacement  string        `yaml:"replacement"`
+	Destinations []Destination `yaml:"destinations"`
+}
+
+// ModeDocker 走现有的 docker pull/tag/push 流程，依赖本地 dockerd
+const ModeDocker = "docker"
+
+// ModeRegistry 直接调用 Registry v2 HTTP API 搬运镜像，不依赖本地 dockerd，
+// 优先使用 cross-repo blob mount 避免把镜像层落盘
+const ModeRegistry = "registry"
+
+// DestinationResult 记录镜像搬运到单个目标仓库的结果
+type DestinationResult struct {
+	Registry        string
+	TargetImageName string
+	Success         bool
+	Error           string
+	// Artifacts 是随镜像一起搬运成功的 cosign sibling 后缀(.sig/.att/.sbom)
+	Artifacts []string
+}
+
+// TagResult 记录 all-tags 模式下单个 tag 搬运到所有目标仓库的结果。
+// Error 记录这个 tag 本身搬运失败的原因(例如规则没有命中、规则没有配置任何
+// 目标仓库)，这种情况下 Destinations 会是空的，不能只看 Destinations 判断结果
+type TagResult struct {
+	Tag          string
+	Destinations []DestinationResult
+	Error        string
 }
 
 // Result 用来记录执行结果
 type Result struct {
 	Success         bool
-	Registry        string
-	RegistryUser    string
+	Partial         bool
 	OriginImageName string
-	TargetImageName string
+	Destinations    []DestinationResult
+	Tags            []TagResult
 	GhUser          string
 	Repo            string
 	RunId           string
@@ -89,13 +155,12 @@ func init() {
 	pflag.CommandLine.StringVarP(&config.GhToken, "github.token", "t", "", "Github token.")
 	pflag.CommandLine.StringVarP(&config.GhUser, "github.user", "u", "", "Github Owner.")
 	pflag.CommandLine.StringVarP(&config.Repo, "github.repo", "p", "", "Github Repo.")
-	pflag.CommandLine.StringVarP(&config.Registry, "docker.registry", "r", "", "Docker Registry.")
-	pflag.CommandLine.StringVarP(&config.RegistryNamespace, "docker.namespace", "n", "", "Docker Registry Namespace.")
-	pflag.CommandLine.StringVarP(&config.RegistryUserName, "docker.user", "a", "", "Docker Registry User.")
-	pflag.CommandLine.StringVarP(&config.RegistryPassword, "docker.secret", "s", "", "Docker Registry Password.")
 	pflag.CommandLine.StringVarP(&config.RunId, "github.run_id", "i", "", "Github Run Id.")
 	pflag.CommandLine.IntVarP(&config.MaxCount, "github.max_count", "m", 1, "max count issue process for one time.")
 	pflag.CommandLine.StringVarP(&config.RulesFile, "rules.file", "c", RulesFile, "rules mapping file")
+	pflag.CommandLine.StringVar(&config.Mode, "mode", ModeDocker, "mirror mode, \"docker\"(pull/tag/push via dockerd) or \"registry\"(talk to registry v2 API directly, no dockerd required)")
+	pflag.CommandLine.IntVar(&config.SyncParallel, "sync.parallel", 4, "how many tags to mirror concurrently in all-tags mode")
+	pflag.CommandLine.BoolVar(&config.CopySignatures, "copy-signatures", true, "also mirror cosign signature/attestation/SBOM sibling tags alongside the image")
 }
 
 func main() {
@@ -103,19 +168,20 @@ func main() {
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
 
-	// 给一个默认的映射，key=>仓库前缀 value=>推动到docker hub的repository
-	config.Rules = map[string]string{
-		"^gcr.io":          "",
-		"^docker.io":       "docker",
-		"^k8s.gcr.io":      "google-containers",
-		"^registry.k8s.io": "google-containers",
-		"^quay.io":         "quay",
-		"^ghcr.io":         "ghcr",
+	// 给一个默认的映射，Pattern=>匹配的域名 Replacement=>推送到docker hub的repository前缀；
+	// 没有配置 rules.yaml 时只负责改写，不会有任何 Destinations 可以推送
+	config.Rules = []Rule{
+		{Pattern: "^gcr.io"},
+		{Pattern: "^docker.io", Replacement: "docker"},
+		{Pattern: "^k8s.gcr.io", Replacement: "google-containers"},
+		{Pattern: "^registry.k8s.io", Replacement: "google-containers"},
+		{Pattern: "^quay.io", Replacement: "quay"},
+		{Pattern: "^ghcr.io", Replacement: "ghcr"},
 	}
 
-	// 从外部文件读取映射关系
+	// 从外部文件读取规则与每条规则对应的目标仓库列表
 	if rulesFile, err := ioutil.ReadFile(RulesFile); err == nil {
-		rules := make(map[string]string)
+		var rules []Rule
 		if err := yaml.Unmarshal(rulesFile, &rules); err == nil {
 			config.Rules = rules
 		}
@@ -155,7 +221,7 @@ func main() {
 			if err := commentIssues(issue, githubCli, ctx, "[构建进展](https://github.com/"+config.GhUser+"/"+config.Repo+"/actions/runs/"+config.RunId+")"); err != nil {
 				fmt.Println("提交 添加 构建进展 Comment 报错", err)
 			}
-			err, originImageName, targetImageName := mirrorByIssues(issue, config)
+			err, originImageName, destResults, tagResults := mirrorByIssues(issue, config)
 			if err != nil {
 				commentErr := commentIssues(issue, githubCli, ctx, err.Error())
 				if commentErr != nil {
@@ -163,12 +229,35 @@ func main() {
 				}
 			}
 			// 将执行结果写入到Issue中
+			successCount, failureCount := 0, 0
+			for _, r := range destResults {
+				if r.Success {
+					successCount++
+				} else {
+					failureCount++
+				}
+			}
+			for _, t := range tagResults {
+				if len(t.Destinations) == 0 {
+					// 规则没有命中或者没有配置目标仓库，这个 tag 直接算失败，
+					// 不能因为 Destinations 是空的就在统计里被无声跳过
+					failureCount++
+					continue
+				}
+				for _, r := range t.Destinations {
+					if r.Success {
+						successCount++
+					} else {
+						failureCount++
+					}
+				}
+			}
 			result := Result{
-				Success:         err == nil,
-				Registry:        config.Registry,
-				RegistryUser:    config.RegistryUserName,
+				Success:         err == nil && successCount > 0 && failureCount == 0,
+				Partial:         successCount > 0 && failureCount > 0,
 				OriginImageName: originImageName,
-				TargetImageName: targetImageName,
+				Destinations:    destResults,
+				Tags:            tagResults,
 				GhUser:          config.GhUser,
 				Repo:            config.Repo,
 				RunId:           config.RunId,
@@ -190,7 +279,7 @@ func main() {
 			}
 
 			fmt.Println("添加 转换结果 Label")
-			issuesAddLabels(issue, githubCli, ctx, result.Success)
+			issuesAddLabels(issue, githubCli, ctx, result.Success, result.Partial)
 
 			fmt.Println("关闭 Issues")
 			issuesClose(issue, githubCli, ctx)
@@ -206,11 +295,14 @@ func issuesClose(issues *github.Issue, cli *github.Client, ctx context.Context)
 		State: &state,
 	})
 }
-func issuesAddLabels(issues *github.Issue, cli *github.Client, ctx context.Context, success bool) {
+func issuesAddLabels(issues *github.Issue, cli *github.Client, ctx context.Context, success bool, partial bool) {
 	names := strings.Split(*issues.RepositoryURL, "/")
 
 	label := "success"
-	if !success {
+	switch {
+	case partial:
+		label = "partial"
+	case !success:
 		label = "failed"
 	}
 	_, _, _ = cli.Issues.AddLabelsToIssue(ctx, names[len(names)-2], names[len(names)-1], issues.GetNumber(), []string{label})
@@ -223,70 +315,217 @@ func commentIssues(issues *github.Issue, cli *github.Client, ctx context.Context
 	return err
 }
 
-func mirrorByIssues(issues *github.Issue, config *Config) (err error, originImageName string, targetImageName string) {
-	// 去掉前缀 [PORTER] 整体去除前后空格
-	originImageName = strings.TrimSpace(strings.Replace(*issues.Title, "[PORTER]", "", 1))
-	targetImageName = originImageName
+// allTagsMarker 是 tag 位置上代表“同步整个仓库”的特殊写法，类似 docker pull --all-tags
+const allTagsMarker = "*"
+
+func mirrorByIssues(issues *github.Issue, config *Config) (err error, originImageName string, results []DestinationResult, tagResults []TagResult) {
+	// 去掉 [PORTER]/[PORTER-ALL] 前缀，整体去除前后空格
+	all := strings.Contains(*issues.Title, LabelPorterAll)
+	title := *issues.Title
+	if all {
+		title = strings.Replace(title, LabelPorterAll, "", 1)
+	} else {
+		title = strings.Replace(title, "[PORTER]", "", 1)
+	}
+	originImageName = strings.TrimSpace(title)
 
-	if strings.ContainsAny(originImageName, "@") {
-		return errors.New("@" + *issues.GetUser().Login + " 不支持同步带摘要信息的镜像"), originImageName, targetImageName
+	ref, parseErr := reference.ParseNamed(originImageName)
+	if parseErr != nil {
+		return errors.New("@" + *issues.GetUser().Login + " 镜像引用解析报错 `" + parseErr.Error() + "`"), originImageName, nil, nil
 	}
+	all = all || ref.Tag == allTagsMarker
 
-	registries := make([]string, 0)
-	for k, v := range config.Rules {
-		targetImageName = regexp.MustCompile(k).ReplaceAllString(targetImageName, v)
-		registries = append(registries, k)
+	rewriteRules := make([]reference.RewriteRule, 0, len(config.Rules))
+	patterns := make([]string, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		rewriteRules = append(rewriteRules, reference.RewriteRule{Pattern: rule.Pattern, Replacement: rule.Replacement})
+		patterns = append(patterns, rule.Pattern)
 	}
 
-	if strings.EqualFold(targetImageName, originImageName) {
-		return errors.New("@" + *issues.GetUser().Login + " 暂不支持同步" + originImageName + ",目前仅支持同步 `" + strings.Join(registries, " ,") + "`镜像"), originImageName, targetImageName
+	if all {
+		tagResults, err = mirrorAllTags(issues, ref, rewriteRules, patterns, config)
+		return err, originImageName, nil, tagResults
 	}
 
-	targetImageName = strings.ReplaceAll(targetImageName, "/", ".")
+	results, err = mirrorOneRef(issues, originImageName, ref, rewriteRules, patterns, config)
+	return err, originImageName, results, nil
+}
 
-	if len(config.RegistryNamespace) > 0 {
-		targetImageName = config.RegistryNamespace + "/" + targetImageName
+// mirrorOneRef 把一个具体的 tag/digest 引用按命中的规则搬运到对应的目标仓库
+func mirrorOneRef(issues *github.Issue, originImageName string, ref reference.Reference, rewriteRules []reference.RewriteRule, patterns []string, config *Config) ([]DestinationResult, error) {
+	rewritten, idx, matched := ref.Rewrite(rewriteRules)
+	if !matched {
+		return nil, errors.New("@" + *issues.GetUser().Login + " 暂不支持同步" + originImageName + ",目前仅支持同步 `" + strings.Join(patterns, " ,") + "`镜像")
 	}
-	if len(config.Registry) > 0 {
-		targetImageName = config.Registry + "/" + targetImageName
+
+	rule := config.Rules[idx]
+	if len(rule.Destinations) == 0 {
+		return nil, errors.New("@" + *issues.GetUser().Login + " 规则 `" + rule.Pattern + "` 未配置任何目标仓库")
+	}
+
+	basePath := strings.ReplaceAll(rewritten.Path, "/", ".")
+	tagOrDigest := ":" + rewritten.Tag
+	if rewritten.Digest != "" {
+		tagOrDigest = "@" + rewritten.Digest
+	}
+
+	// 并行推送到规则里配置的每一个目标仓库，结果单独记录互不影响
+	resultsCh := make(chan DestinationResult, len(rule.Destinations))
+	destWg := sync.WaitGroup{}
+	for _, dest := range rule.Destinations {
+		destWg.Add(1)
+		go func(dest Destination) {
+			defer destWg.Done()
+
+			targetImageName := basePath + tagOrDigest
+			if len(dest.RegistryNamespace) > 0 {
+				targetImageName = dest.RegistryNamespace + "/" + targetImageName
+			}
+			if len(dest.Registry) > 0 {
+				targetImageName = dest.Registry + "/" + targetImageName
+			}
+			fmt.Println("source:", originImageName, " , target:", targetImageName)
+
+			artifacts, mirrorErr := mirrorOneDestination(originImageName, targetImageName, config, dest)
+			if mirrorErr != nil {
+				resultsCh <- DestinationResult{Registry: dest.Registry, TargetImageName: targetImageName, Success: false, Error: mirrorErr.Error()}
+				return
+			}
+			resultsCh <- DestinationResult{Registry: dest.Registry, TargetImageName: targetImageName, Success: true, Artifacts: artifacts}
+		}(dest)
+	}
+	destWg.Wait()
+	close(resultsCh)
+
+	var err error
+	results := make([]DestinationResult, 0, len(rule.Destinations))
+	for r := range resultsCh {
+		results = append(results, r)
+		if !r.Success && err == nil {
+			err = errors.New("@" + *issues.GetUser().Login + " ,搬运到 `" + r.Registry + "` 报错 `" + r.Error + "`")
+		}
 	}
-	fmt.Println("source:", originImageName, " , target:", targetImageName)
+	return results, err
+}
 
-	//execCmd("docker", "login", config.Registry, "-u", config.RegistryUserName, "-p", config.RegistryPassword)
-	cli, ctx, err := dockerLogin(config)
+// mirrorAllTags 枚举源仓库的所有 tag，并以 config.SyncParallel 的并发度逐个搬运，
+// 结果按 tag 汇总，单个 tag 失败不影响其它 tag 继续搬运
+func mirrorAllTags(issues *github.Issue, ref reference.Reference, rewriteRules []reference.RewriteRule, patterns []string, config *Config) ([]TagResult, error) {
+	tags, err := newRegistryClient(Destination{}).listTags(registryRefFromReference(ref))
 	if err != nil {
-		return errors.New("@" + config.GhUser + " ,docker login 报错 `" + err.Error() + "`"), originImageName, targetImageName
+		return nil, errors.New("@" + *issues.GetUser().Login + " 获取 tags 列表报错 `" + err.Error() + "`")
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("@" + *issues.GetUser().Login + " 仓库 " + ref.Domain + "/" + ref.Path + " 下没有任何 tag")
 	}
 
-	//execCmd("docker", "pull", originImageName)
-	if err = dockerPull(originImageName, cli, ctx); err != nil {
-		return errors.New("@" + *issues.GetUser().Login + " ,docker pull 报错 `" + err.Error() + "`"), originImageName, targetImageName
+	parallel := config.SyncParallel
+	if parallel <= 0 {
+		parallel = 1
 	}
+	sem := make(chan struct{}, parallel)
+	tagResultsCh := make(chan TagResult, len(tags))
+	tagWg := sync.WaitGroup{}
+	for _, tag := range tags {
+		tagWg.Add(1)
+		go func(tag string) {
+			defer tagWg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			tagRef := ref
+			tagRef.Tag, tagRef.Digest = tag, ""
+			originImageName := tagRef.String()
+			destResults, tagErr := mirrorOneRef(issues, originImageName, tagRef, rewriteRules, patterns, config)
+			tagResult := TagResult{Tag: tag, Destinations: destResults}
+			if tagErr != nil {
+				tagResult.Error = tagErr.Error()
+			}
+			tagResultsCh <- tagResult
+		}(tag)
+	}
+	tagWg.Wait()
+	close(tagResultsCh)
 
-	//execCmd("docker", "tag", originImageName, targetImageName)
-	if err = dockerTag(originImageName, targetImageName, cli, ctx); err != nil {
-		return errors.New("@" + *issues.GetUser().Login + " ,docker tag 报错 `" + err.Error() + "`"), originImageName, targetImageName
+	tagResults := make([]TagResult, 0, len(tags))
+	for t := range tagResultsCh {
+		tagResults = append(tagResults, t)
 	}
+	return tagResults, nil
+}
 
-	//execCmd("docker", "push", targetImageName)
-	if err = dockerPush(targetImageName, cli, ctx, config); err != nil {
-		return errors.New("@" + *issues.GetUser().Login + " ,docker push 报错 `" + err.Error() + "`"), originImageName, targetImageName
+// mirrorOneDestination 把镜像搬运到单个目标仓库，支持 docker 模式与 registry 模式，
+// 成功后如果开启了 CopySignatures，再额外搬运 cosign 签名/attestation/SBOM
+func mirrorOneDestination(originImageName, targetImageName string, config *Config, dest Destination) ([]string, error) {
+	if config.Mode == ModeRegistry {
+		if err := mirrorByRegistry(originImageName, targetImageName, dest); err != nil {
+			return nil, err
+		}
+	} else {
+		//execCmd("docker", "login", dest.Registry, "-u", dest.RegistryUserName, "-p", dest.RegistryPassword)
+		cli, ctx, err := dockerLogin(dest)
+		if err != nil {
+			return nil, fmt.Errorf("docker login 报错 `%s`", err.Error())
+		}
+
+		//execCmd("docker", "pull", originImageName)
+		if err = dockerPull(originImageName, cli, ctx); err != nil {
+			return nil, fmt.Errorf("docker pull 报错 `%s`", err.Error())
+		}
+
+		//execCmd("docker", "tag", originImageName, targetImageName)
+		if err = dockerTag(originImageName, targetImageName, cli, ctx); err != nil {
+			return nil, fmt.Errorf("docker tag 报错 `%s`", err.Error())
+		}
+
+		//execCmd("docker", "push", targetImageName)
+		if err = dockerPush(targetImageName, cli, ctx, dest); err != nil {
+			return nil, fmt.Errorf("docker push 报错 `%s`", err.Error())
+		}
 	}
 
-	return nil, originImageName, targetImageName
+	if !config.CopySignatures {
+		return nil, nil
+	}
+	return propagateSignatures(originImageName, targetImageName, dest), nil
 }
 
-func dockerLogin(config *Config) (*client.Client, context.Context, error) {
+// registryHost 把 Destination.Registry 归一化成凭证解析实际用来匹配的 host：
+// Registry 留空表示直接推送到 Docker Hub，与 parseRegistryRef 对空域名的默认值
+// 保持一致，否则 --mode=registry 下 doWithAuth 传入的 endpoint(registry-1.docker.io)
+// 永远匹配不上 docker 模式下直接传入的空字符串，配置的静态账号密码会被悄悄忽略
+func registryHost(dest Destination) string {
+	if dest.Registry == "" {
+		return defaultRegistryEndpoint
+	}
+	return dest.Registry
+}
+
+// resolverFor 组装某个目标仓库的凭证解析链：优先使用 rules.yaml/命令行里配置的
+// 静态账号密码，其次尝试 ~/.docker/config.json，最后尝试云厂商的 CLI 工具
+// (gcloud/aws/az)，这样 CI 里不需要把密码当作命令行参数传入就能登录私有仓库
+func resolverFor(dest Destination) credentials.Resolver {
+	return credentials.ChainResolver{
+		credentials.StaticResolver{Registry: registryHost(dest), Username: dest.RegistryUserName, Password: dest.RegistryPassword},
+		credentials.NewDockerConfigResolver(),
+		credentials.CloudResolver{},
+	}
+}
+
+func dockerLogin(dest Destination) (*client.Client, context.Context, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, nil, err
 	}
-	fmt.Println("docker login, server: ", config.Registry, " user: ", config.RegistryUserName, ", password: ***")
-	authConfig := types.AuthConfig{
-		Username:      config.RegistryUserName,
-		Password:      config.RegistryPassword,
-		ServerAddress: config.Registry,
+	authConfig, err := resolverFor(dest).AuthFor(registryHost(dest))
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析 %s 的登录凭证报错: %w", dest.Registry, err)
 	}
+	// registryHost 只是用来在凭证解析链里匹配 Destination，docker 模式下
+	// RegistryLogin 实际看的是 ServerAddress，留空时沿用 dockerd 自己对 Docker
+	// Hub 的默认处理，不应该被归一化后的 host 悄悄改变
+	authConfig.ServerAddress = dest.Registry
+	fmt.Println("docker login, server: ", authConfig.ServerAddress, " user: ", authConfig.Username, ", password: ***")
 	ctx := context.Background()
 	_, err = cli.RegistryLogin(ctx, authConfig)
 	if err != nil {
@@ -311,15 +550,15 @@ func dockerTag(originImageName string, targetImageName string, cli *client.Clien
 	err := cli.ImageTag(ctx, originImageName, targetImageName)
 	return err
 }
-func dockerPush(targetImageName string, cli *client.Client, ctx context.Context, config *Config) error {
+func dockerPush(targetImageName string, cli *client.Client, ctx context.Context, dest Destination) error {
 	fmt.Println("docker push ", targetImageName)
-	authConfig := types.AuthConfig{
-		Username: config.RegistryUserName,
-		Password: config.RegistryPassword,
-	}
-	if len(config.Registry) > 0 {
-		authConfig.ServerAddress = config.Registry
+	authConfig, err := resolverFor(dest).AuthFor(registryHost(dest))
+	if err != nil {
+		return fmt.Errorf("解析 %s 的登录凭证报错: %w", dest.Registry, err)
 	}
+	// 原因同 dockerLogin：ImagePush 的 RegistryAuth 也认 ServerAddress，
+	// 保持和搬运前 dest.Registry 原始值一致，不受凭证匹配用的归一化影响
+	authConfig.ServerAddress = dest.Registry
 	encodedJSON, err := json.Marshal(authConfig)
 	if err != nil {
 		return err
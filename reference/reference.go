@@ -0,0 +1,131 @@
+// Package reference 提供一个精简版的镜像引用解析器，建模方式类似
+// github.com/docker/docker/reference 里的 parsers.ParseRepositoryTag，
+// 把一个镜像引用拆成 domain、path、tag、digest 四个部分，方便上层只对
+// domain+path 做仓库改写，而不用小心翼翼地绕开 tag/digest。
+package reference
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain 是镜像引用没有带域名时使用的默认仓库地址
+	DefaultDomain = "docker.io"
+	// DefaultRepoPrefix 是仓库路径只有一段(没有命名空间)时补齐的默认命名空间
+	DefaultRepoPrefix = "library"
+	// DefaultTag 是既没有 tag 也没有 digest 时使用的默认 tag
+	DefaultTag = "latest"
+)
+
+// domainPattern 判断 name 被 "/" 分割后的第一段是不是域名：
+// 要么是 localhost，要么含有 "." 或 ":"（区分 "foo/bar" 和 "registry:5000/bar"）
+var domainPattern = regexp.MustCompile(`^(localhost|[^/]+[.:][^/]*)$`)
+
+// digestPattern 校验 algorithm:hex 形式的 digest，例如 sha256:<64 个十六进制字符>
+var digestPattern = regexp.MustCompile(`^[a-zA-Z0-9+._-]+:[a-fA-F0-9]{32,}$`)
+
+// Reference 是镜像引用拆分后的各个组成部分。Tag 和 Digest 可以同时存在
+// (例如 foo:v1@sha256:...)，String 拼接时 Digest 优先；
+// 两者都为空只会在内部构造临时值时出现，ParseNamed 返回值里至少会有一个
+type Reference struct {
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// ParseNamed 解析形如 gcr.io/foo/bar:tag、foo/bar@sha256:xxx、
+// registry:5000/foo、busybox 这样的镜像引用
+func ParseNamed(s string) (Reference, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Reference{}, fmt.Errorf("reference: 镜像引用不能为空")
+	}
+
+	var ref Reference
+	remainder := s
+
+	if at := strings.Index(remainder, "@"); at >= 0 {
+		ref.Digest = remainder[at+1:]
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("reference: 非法的 digest %q", ref.Digest)
+		}
+		remainder = remainder[:at]
+	}
+
+	if c := strings.LastIndex(remainder, ":"); c >= 0 && !strings.Contains(remainder[c:], "/") {
+		ref.Tag = remainder[c+1:]
+		remainder = remainder[:c]
+	}
+
+	if remainder == "" {
+		return Reference{}, fmt.Errorf("reference: 缺少仓库路径")
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+
+	if parts := strings.SplitN(remainder, "/", 2); len(parts) == 2 && domainPattern.MatchString(parts[0]) {
+		ref.Domain = parts[0]
+		ref.Path = parts[1]
+	} else {
+		ref.Domain = DefaultDomain
+		ref.Path = remainder
+		if !strings.Contains(ref.Path, "/") {
+			ref.Path = DefaultRepoPrefix + "/" + ref.Path
+		}
+	}
+	return ref, nil
+}
+
+// RewriteRule 是一条 domain 改写规则：Pattern 是匹配 Domain 的正则，
+// Replacement 是命中后替换成的仓库前缀
+type RewriteRule struct {
+	Pattern     string
+	Replacement string
+}
+
+// Rewrite 依次用 rules 去匹配 Domain，命中第一条规则后把 Domain 替换成
+// Replacement 并入 Path，Tag/Digest 保持不变。regexp 语法意味着规则不再需要
+// "^" 锚定就能匹配 Domain 整体，因为 Domain 已经是独立字段。
+// 返回值里的下标是命中规则在 rules 里的位置，没有命中时为 -1。
+func (r Reference) Rewrite(rules []RewriteRule) (Reference, int, bool) {
+	for i, rule := range rules {
+		// 整体锚定，Pattern 必须匹配整个 Domain，"gcr.io" 不会命中 "k8s.gcr.io"
+		re, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil || !re.MatchString(r.Domain) {
+			continue
+		}
+		out := r
+		prefix := re.ReplaceAllString(r.Domain, rule.Replacement)
+		if prefix == "" {
+			out.Path = r.Path
+		} else {
+			out.Path = prefix + "/" + r.Path
+		}
+		out.Domain = ""
+		return out, i, true
+	}
+	return r, -1, false
+}
+
+// String 把各部分重新拼接成一个完整的镜像引用
+func (r Reference) String() string {
+	var b strings.Builder
+	if r.Domain != "" {
+		b.WriteString(r.Domain)
+		b.WriteString("/")
+	}
+	b.WriteString(r.Path)
+	if r.Digest != "" {
+		b.WriteString("@")
+		b.WriteString(r.Digest)
+	} else if r.Tag != "" {
+		b.WriteString(":")
+		b.WriteString(r.Tag)
+	}
+	return b.String()
+}
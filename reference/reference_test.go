@@ -0,0 +1,121 @@
+package reference
+
+import "testing"
+
+func TestParseNamed(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    Reference
+		wantErr bool
+	}{
+		{
+			name: "default domain and library namespace",
+			in:   "busybox",
+			want: Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: DefaultTag},
+		},
+		{
+			name: "default domain, namespace already present",
+			in:   "library/busybox:1.36",
+			want: Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: "1.36"},
+		},
+		{
+			name: "explicit domain with tag",
+			in:   "gcr.io/foo/bar:v1",
+			want: Reference{Domain: "gcr.io", Path: "foo/bar", Tag: "v1"},
+		},
+		{
+			name: "digest pinned, no tag",
+			in:   "gcr.io/foo/bar@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want: Reference{Domain: "gcr.io", Path: "foo/bar", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			name: "tag and digest combined, digest wins over tag lookup but both kept",
+			in:   "gcr.io/foo/bar:v1@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want: Reference{Domain: "gcr.io", Path: "foo/bar", Tag: "v1", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			name: "domain with port",
+			in:   "registry:5000/foo/bar",
+			want: Reference{Domain: "registry:5000", Path: "foo/bar", Tag: DefaultTag},
+		},
+		{
+			name: "domain with port and tag",
+			in:   "registry:5000/foo/bar:v2",
+			want: Reference{Domain: "registry:5000", Path: "foo/bar", Tag: "v2"},
+		},
+		{
+			name: "multi-segment path",
+			in:   "ghcr.io/org/team/service:latest",
+			want: Reference{Domain: "ghcr.io", Path: "org/team/service", Tag: "latest"},
+		},
+		{
+			name:    "empty reference",
+			in:      "",
+			wantErr: true,
+		},
+		{
+			name:    "invalid digest",
+			in:      "gcr.io/foo/bar@not-a-digest",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseNamed(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseNamed(%q) expected error, got %+v", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNamed(%q) unexpected error: %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Fatalf("ParseNamed(%q) = %+v, want %+v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReferenceRewrite(t *testing.T) {
+	rules := []RewriteRule{
+		{Pattern: "^gcr.io$", Replacement: ""},
+		{Pattern: "^docker.io$", Replacement: "docker"},
+		{Pattern: "^k8s.gcr.io$", Replacement: "google-containers"},
+		{Pattern: "^registry.k8s.io$", Replacement: "google-containers"},
+	}
+
+	cases := []struct {
+		name      string
+		in        string
+		wantPath  string
+		wantIndex int
+		wantOK    bool
+	}{
+		{name: "gcr.io collapses to bare path", in: "gcr.io/foo/bar:v1", wantPath: "foo/bar", wantIndex: 0, wantOK: true},
+		{name: "k8s.gcr.io prefixes path", in: "k8s.gcr.io/pause:3.9", wantPath: "google-containers/pause", wantIndex: 2, wantOK: true},
+		{name: "no matching rule", in: "quay.io/foo/bar:v1", wantPath: "foo/bar", wantIndex: -1, wantOK: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseNamed(tc.in)
+			if err != nil {
+				t.Fatalf("ParseNamed(%q) unexpected error: %v", tc.in, err)
+			}
+			out, idx, ok := ref.Rewrite(rules)
+			if ok != tc.wantOK {
+				t.Fatalf("Rewrite(%q) ok = %v, want %v", tc.in, ok, tc.wantOK)
+			}
+			if idx != tc.wantIndex {
+				t.Fatalf("Rewrite(%q) index = %d, want %d", tc.in, idx, tc.wantIndex)
+			}
+			if ok && out.Path != tc.wantPath {
+				t.Fatalf("Rewrite(%q) path = %q, want %q", tc.in, out.Path, tc.wantPath)
+			}
+		})
+	}
+}
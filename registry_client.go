@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MainPoser/gcr.io_mirror/credentials"
+	"github.com/MainPoser/gcr.io_mirror/reference"
+)
+
+// defaultRegistryEndpoint 是镜像名没有显式域名时使用的默认仓库地址，与 docker 行为一致
+const defaultRegistryEndpoint = "registry-1.docker.io"
+
+// acceptManifestTypes 是拉取 manifest 时接受的类型，同时覆盖单架构镜像和
+// 多架构的 manifest list / OCI index，避免多架构镜像搬运时丢失其它平台
+var acceptManifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// registryRef 是对镜像引用的拆解结果：endpoint(仓库域名)、repository(仓库路径)
+// 以及 tag、digest(两者可以同时存在，拼 URL 时 digest 优先，见 tagOrDigest)
+type registryRef struct {
+	Endpoint   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// descriptor 对应 manifest 中对 config/layer/子 manifest 的描述
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// manifest 只保留我们搬运时关心的公共字段，单架构镜像和 manifest list 共用
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+// parseRegistryRef 把形如 gcr.io/foo/bar:tag、foo/bar@sha256:xxx 的镜像名
+// 拆成 endpoint + repository + tag/digest，解析规则与原先的字符串匹配保持一致
+func parseRegistryRef(image string) registryRef {
+	ref := registryRef{Endpoint: defaultRegistryEndpoint}
+
+	name := image
+	if at := strings.Index(name, "@"); at >= 0 {
+		ref.Digest = name[at+1:]
+		name = name[:at]
+	}
+	if c := strings.LastIndex(name, ":"); c >= 0 && !strings.Contains(name[c:], "/") {
+		ref.Tag = name[c+1:]
+		name = name[:c]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 && (strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":")) {
+		ref.Endpoint = parts[0]
+		ref.Repository = parts[1]
+	} else {
+		ref.Repository = name
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = "library/" + ref.Repository
+		}
+	}
+	// 镜像名里写的 "docker.io" 只是习惯上的默认域名，真正对外服务 v2 API 的是
+	// registry-1.docker.io，与 registryRefFromReference 保持同样的映射
+	if ref.Endpoint == reference.DefaultDomain {
+		ref.Endpoint = defaultRegistryEndpoint
+	}
+	return ref
+}
+
+// registryRefFromReference 把 reference.Reference 转成 registryRef，
+// 统一把 reference 包里的默认域名 "docker.io" 映射成实际要访问的 endpoint
+func registryRefFromReference(ref reference.Reference) registryRef {
+	endpoint := ref.Domain
+	if endpoint == "" || endpoint == reference.DefaultDomain {
+		endpoint = defaultRegistryEndpoint
+	}
+	return registryRef{Endpoint: endpoint, Repository: ref.Path, Tag: ref.Tag, Digest: ref.Digest}
+}
+
+// tagOrDigest 返回引用里可以直接拼在 URL 上的那一段(tag 或 digest)
+func (r registryRef) tagOrDigest() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// registryClient 直接调用 Registry v2 HTTP API 完成镜像搬运，跳过本地 dockerd，
+// 通过 cross-repo blob mount 尽量避免把镜像层落盘再重新上传
+type registryClient struct {
+	httpCli  *http.Client
+	dest     Destination
+	resolver credentials.Resolver
+}
+
+func newRegistryClient(dest Destination) *registryClient {
+	return &registryClient{httpCli: &http.Client{}, dest: dest, resolver: resolverFor(dest)}
+}
+
+// authFor 通过 resolverFor 组装的凭证解析链返回某个 endpoint 对应的账号密码，
+// 解析失败(例如源仓库是公开仓库，没有任何解析器认识它)时按匿名身份访问
+func (c *registryClient) authFor(endpoint string) (string, string) {
+	auth, err := c.resolver.AuthFor(endpoint)
+	if err != nil {
+		return "", ""
+	}
+	return auth.Username, auth.Password
+}
+
+// doWithAuth 发起一次请求，遇到 401 + Www-Authenticate: Bearer 时按挑战信息换取
+// token 后重试一次，兼容 gcr.io/docker hub 这类需要 bearer token 的仓库。
+// 带请求体的请求重试时通过 GetBody 重新取得请求体；没有 GetBody 的请求体
+// (例如流式上传的 blob)无法重放，这时不重试，直接返回错误
+func (c *registryClient) doWithAuth(req *http.Request, endpoint string) (*http.Response, error) {
+	user, pass := c.authFor(endpoint)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("Www-Authenticate")
+	_ = resp.Body.Close()
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return resp, nil
+	}
+	token, err := c.bearerToken(challenge, user, pass)
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("请求体无法重放，不能带 token 重试 %s %s", req.Method, req.URL)
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return c.httpCli.Do(retry)
+}
+
+// bearerToken 按 Www-Authenticate: Bearer realm=...,service=...,scope=... 的挑战
+// 信息向 realm 换取一个短时 token
+func (c *registryClient) bearerToken(challenge, user, pass string) (string, error) {
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		params[parts[0]] = strings.Trim(parts[1], `"`)
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("无法解析 Www-Authenticate 挑战: %s", challenge)
+	}
+	q := url.Values{}
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		q.Set("scope", scope)
+	}
+	tokenURL := realm + "?" + q.Encode()
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("获取 token 失败, status: %d", resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func (c *registryClient) baseURL(endpoint string) string {
+	return "https://" + endpoint + "/v2"
+}
+
+// fetchManifest 通过 GET 拉取 manifest(同时带上 Accept 头以支持 manifest list/OCI index)
+func (c *registryClient) fetchManifest(ref registryRef) (manifest, string, error) {
+	reqURL := fmt.Sprintf("%s/%s/manifests/%s", c.baseURL(ref.Endpoint), ref.Repository, ref.tagOrDigest())
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return manifest{}, "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptManifestTypes, ","))
+	resp, err := c.doWithAuth(req, ref.Endpoint)
+	if err != nil {
+		return manifest{}, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return manifest{}, "", fmt.Errorf("获取 manifest 失败 %s, status: %d", reqURL, resp.StatusCode)
+	}
+	var m manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return manifest{}, "", err
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+	return m, resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// listTags 枚举仓库的所有 tag，通过响应的 Link 头翻页直至没有下一页，
+// 等价于 docker pull --all-tags 前先拿到完整 tag 列表
+func (c *registryClient) listTags(ref registryRef) ([]string, error) {
+	var tags []string
+	reqURL := fmt.Sprintf("%s/%s/tags/list", c.baseURL(ref.Endpoint), ref.Repository)
+	for reqURL != "" {
+		req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.doWithAuth(req, ref.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+		status := resp.StatusCode
+		link := resp.Header.Get("Link")
+		_ = resp.Body.Close()
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("获取 tags 列表失败 %s, status: %d", reqURL, status)
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		tags = append(tags, body.Tags...)
+		reqURL = nextLinkURL(ref.Endpoint, link)
+	}
+	return tags, nil
+}
+
+// nextLinkURL 解析形如 `</v2/foo/tags/list?n=100&last=bar>; rel="next"` 的
+// Link 响应头，返回下一页的完整 URL；没有下一页时返回空字符串
+func nextLinkURL(endpoint, link string) string {
+	if link == "" {
+		return ""
+	}
+	for _, part := range strings.Split(link, ",") {
+		segs := strings.SplitN(strings.TrimSpace(part), ";", 2)
+		if len(segs) != 2 || !strings.Contains(segs[1], `rel="next"`) {
+			continue
+		}
+		next := strings.Trim(strings.TrimSpace(segs[0]), "<>")
+		if strings.HasPrefix(next, "http://") || strings.HasPrefix(next, "https://") {
+			return next
+		}
+		return "https://" + endpoint + next
+	}
+	return ""
+}
+
+// mountBlob 尝试跨仓库挂载一个 blob(不下载内容，注册表内部直接引用源仓库的数据)，
+// 挂载被拒绝时返回 false，调用方应当退回到逐层拉取再推送的方式
+func (c *registryClient) mountBlob(srcRef, dstRef registryRef, layer descriptor) (bool, error) {
+	reqURL := fmt.Sprintf("%s/%s/blobs/uploads/?mount=%s&from=%s",
+		c.baseURL(dstRef.Endpoint), dstRef.Repository, layer.Digest, srcRef.Repository)
+	req, err := http.NewRequest(http.MethodPost, reqURL, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.doWithAuth(req, dstRef.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// 201 表示挂载成功；202 表示注册表拒绝了挂载，改为开启了一次普通上传会话，
+	// 这种情况下也当作“未挂载”处理，交由调用方走 pull/push 兜底
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// copyBlob 在跨仓库挂载被拒绝时，流式拉取源 blob 再上传到目标仓库，不落盘
+func (c *registryClient) copyBlob(srcRef, dstRef registryRef, layer descriptor) error {
+	getURL := fmt.Sprintf("%s/%s/blobs/%s", c.baseURL(srcRef.Endpoint), srcRef.Repository, layer.Digest)
+	getReq, err := http.NewRequest(http.MethodGet, getURL, nil)
+	if err != nil {
+		return err
+	}
+	getResp, err := c.doWithAuth(getReq, srcRef.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = getResp.Body.Close() }()
+	if getResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("拉取 blob %s 失败, status: %d", layer.Digest, getResp.StatusCode)
+	}
+
+	startURL := fmt.Sprintf("%s/%s/blobs/uploads/", c.baseURL(dstRef.Endpoint), dstRef.Repository)
+	startReq, err := http.NewRequest(http.MethodPost, startURL, nil)
+	if err != nil {
+		return err
+	}
+	startResp, err := c.doWithAuth(startReq, dstRef.Endpoint)
+	if err != nil {
+		return err
+	}
+	uploadURL := startResp.Header.Get("Location")
+	_ = startResp.Body.Close()
+	if startResp.StatusCode != http.StatusAccepted || uploadURL == "" {
+		return fmt.Errorf("创建 blob 上传会话失败, status: %d", startResp.StatusCode)
+	}
+
+	if !strings.Contains(uploadURL, "?") {
+		uploadURL += "?digest=" + layer.Digest
+	} else {
+		uploadURL += "&digest=" + layer.Digest
+	}
+	putReq, err := http.NewRequest(http.MethodPut, uploadURL, getResp.Body)
+	if err != nil {
+		return err
+	}
+	putReq.ContentLength = layer.Size
+	putReq.Header.Set("Content-Type", "application/octet-stream")
+	putResp, err := c.doWithAuth(putReq, dstRef.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = putResp.Body.Close() }()
+	if putResp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("上传 blob %s 失败, status: %d", layer.Digest, putResp.StatusCode)
+	}
+	return nil
+}
+
+// putManifest 把 manifest 原样上传到目标仓库，保留原始 media type，
+// 这样多架构镜像的 manifest list / OCI index 也能被正确识别
+func (c *registryClient) putManifest(dstRef registryRef, mediaType string, raw []byte) error {
+	reqURL := fmt.Sprintf("%s/%s/manifests/%s", c.baseURL(dstRef.Endpoint), dstRef.Repository, dstRef.tagOrDigest())
+	req, err := http.NewRequest(http.MethodPut, reqURL, strings.NewReader(string(raw)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.doWithAuth(req, dstRef.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("上传 manifest 失败, status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// copyManifestRef 拷贝一个具体 tag/digest 引用指向的 manifest 及其依赖的全部
+// blob，manifest list / OCI index 会递归拷贝每一个子 manifest。优先尝试
+// cross-repo blob mount，仅在挂载被拒绝时才退回到拉取+上传
+func copyManifestRef(client *registryClient, srcRef, dstRef registryRef) ([]byte, string, error) {
+	reqURL := fmt.Sprintf("%s/%s/manifests/%s", client.baseURL(srcRef.Endpoint), srcRef.Repository, srcRef.tagOrDigest())
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptManifestTypes, ","))
+	resp, err := client.doWithAuth(req, srcRef.Endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("获取源 manifest 失败 %s, status: %d", reqURL, resp.StatusCode)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, "", err
+	}
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = resp.Header.Get("Content-Type")
+	}
+
+	// manifest list / OCI index: 逐个子 manifest 递归搬运，保留整体的多架构索引
+	if len(m.Manifests) > 0 {
+		for _, sub := range m.Manifests {
+			subSrc, subDst := srcRef, dstRef
+			subSrc.Tag, subSrc.Digest = "", sub.Digest
+			subDst.Tag, subDst.Digest = "", sub.Digest
+			if _, _, err := copyManifestRef(client, subSrc, subDst); err != nil {
+				return nil, "", fmt.Errorf("搬运子 manifest %s 失败: %w", sub.Digest, err)
+			}
+		}
+		return raw, mediaType, client.putManifest(dstRef, mediaType, raw)
+	}
+
+	layers := append([]descriptor{m.Config}, m.Layers...)
+	for _, layer := range layers {
+		if layer.Digest == "" {
+			continue
+		}
+		mounted, err := client.mountBlob(srcRef, dstRef, layer)
+		if err != nil {
+			return nil, "", err
+		}
+		if mounted {
+			fmt.Println("cross-repo mount 成功:", layer.Digest)
+			continue
+		}
+		fmt.Println("cross-repo mount 被拒绝，退回到拉取+上传:", layer.Digest)
+		if err := client.copyBlob(srcRef, dstRef, layer); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return raw, mediaType, client.putManifest(dstRef, mediaType, raw)
+}
+
+// mirrorByRegistry 是 --mode=registry 下的搬运实现：直接调用 Registry v2 API
+// 拷贝 manifest 和它依赖的全部 blob，不依赖本地 dockerd
+func mirrorByRegistry(originImageName, targetImageName string, dest Destination) error {
+	srcRef := parseRegistryRef(originImageName)
+	dstRef := parseRegistryRef(targetImageName)
+	_, _, err := copyManifestRef(newRegistryClient(dest), srcRef, dstRef)
+	return err
+}
+
+// cosignSuffixes 是 cosign 约定里签名、attestation、SBOM 这几个 sibling tag 的后缀，
+// 存储在 registry 里的 tag 名是 "sha256-<digest hex>" 加上其中一个后缀
+var cosignSuffixes = []string{".sig", ".att", ".sbom"}
+
+// cosignTag 把一个 manifest digest 转换成 cosign 约定的 sibling tag 名，
+// 例如 sha256:abcd... + ".sig" -> sha256-abcd....sig
+func cosignTag(digest, suffix string) string {
+	return strings.NewReplacer(":", "-").Replace(digest) + suffix
+}
+
+// headManifestDigest 通过 HEAD 请求获取 manifest 对应的 digest，不下载 body，
+// 用于探测某个 tag 是否存在，以及拿到源镜像的 manifest digest
+func (c *registryClient) headManifestDigest(ref registryRef) (string, error) {
+	reqURL := fmt.Sprintf("%s/%s/manifests/%s", c.baseURL(ref.Endpoint), ref.Repository, ref.tagOrDigest())
+	req, err := http.NewRequest(http.MethodHead, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptManifestTypes, ","))
+	resp, err := c.doWithAuth(req, ref.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD manifest 失败 %s, status: %d", reqURL, resp.StatusCode)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("响应未返回 Docker-Content-Digest: %s", reqURL)
+	}
+	return digest, nil
+}
+
+// propagateSignatures 探测源镜像是否带有 cosign 签名/attestation/SBOM 这几个
+// sibling tag，存在的话一并搬运到目标仓库，返回实际搬运成功的后缀列表
+func propagateSignatures(originImageName, targetImageName string, dest Destination) []string {
+	srcRef := parseRegistryRef(originImageName)
+	dstRef := parseRegistryRef(targetImageName)
+	client := newRegistryClient(dest)
+
+	digest, err := client.headManifestDigest(srcRef)
+	if err != nil {
+		fmt.Println("获取源镜像 digest 失败，跳过签名/SBOM 搬运:", err)
+		return nil
+	}
+
+	var propagated []string
+	for _, suffix := range cosignSuffixes {
+		tag := cosignTag(digest, suffix)
+		sigSrc, sigDst := srcRef, dstRef
+		sigSrc.Tag, sigSrc.Digest = tag, ""
+		if _, err := client.headManifestDigest(sigSrc); err != nil {
+			continue
+		}
+		sigDst.Tag, sigDst.Digest = tag, ""
+		if _, _, err := copyManifestRef(client, sigSrc, sigDst); err != nil {
+			fmt.Println("搬运 cosign sibling 失败:", tag, err)
+			continue
+		}
+		propagated = append(propagated, suffix)
+	}
+	return propagated
+}
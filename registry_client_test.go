@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MainPoser/gcr.io_mirror/credentials"
+)
+
+func TestParseRegistryRef(t *testing.T) {
+	cases := []struct {
+		name  string
+		image string
+		want  registryRef
+	}{
+		{
+			name:  "default domain and library namespace",
+			image: "busybox",
+			want:  registryRef{Endpoint: defaultRegistryEndpoint, Repository: "library/busybox", Tag: "latest"},
+		},
+		{
+			name:  "default domain, namespace already present",
+			image: "library/busybox:1.36",
+			want:  registryRef{Endpoint: defaultRegistryEndpoint, Repository: "library/busybox", Tag: "1.36"},
+		},
+		{
+			name:  "explicit domain with tag",
+			image: "gcr.io/foo/bar:v1",
+			want:  registryRef{Endpoint: "gcr.io", Repository: "foo/bar", Tag: "v1"},
+		},
+		{
+			name:  "digest pinned, no tag",
+			image: "foo/bar@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want:  registryRef{Endpoint: defaultRegistryEndpoint, Repository: "foo/bar", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			name:  "tag and digest combined, digest wins over tag lookup but both kept",
+			image: "gcr.io/foo/bar:v1@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want:  registryRef{Endpoint: "gcr.io", Repository: "foo/bar", Tag: "v1", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			name:  "domain with port",
+			image: "registry:5000/foo",
+			want:  registryRef{Endpoint: "registry:5000", Repository: "foo", Tag: "latest"},
+		},
+		{
+			name:  "explicit docker.io domain maps to registry-1.docker.io",
+			image: "docker.io/library/busybox:latest",
+			want:  registryRef{Endpoint: defaultRegistryEndpoint, Repository: "library/busybox", Tag: "latest"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseRegistryRef(tc.image)
+			if got != tc.want {
+				t.Fatalf("parseRegistryRef(%q) = %+v, want %+v", tc.image, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextLinkURL(t *testing.T) {
+	cases := []struct {
+		name     string
+		endpoint string
+		link     string
+		want     string
+	}{
+		{name: "empty link", endpoint: "gcr.io", link: "", want: ""},
+		{
+			name:     "relative next link",
+			endpoint: "gcr.io",
+			link:     `</v2/foo/tags/list?n=100&last=bar>; rel="next"`,
+			want:     "https://gcr.io/v2/foo/tags/list?n=100&last=bar",
+		},
+		{
+			name:     "absolute next link",
+			endpoint: "gcr.io",
+			link:     `<https://other.example.com/v2/foo/tags/list?last=bar>; rel="next"`,
+			want:     "https://other.example.com/v2/foo/tags/list?last=bar",
+		},
+		{
+			name:     "no rel=next entry",
+			endpoint: "gcr.io",
+			link:     `</v2/foo/tags/list?last=bar>; rel="prev"`,
+			want:     "",
+		},
+		{
+			name:     "multiple entries, picks the next one",
+			endpoint: "gcr.io",
+			link:     `</v2/foo/tags/list?last=a>; rel="prev", </v2/foo/tags/list?last=b>; rel="next"`,
+			want:     "https://gcr.io/v2/foo/tags/list?last=b",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextLinkURL(tc.endpoint, tc.link); got != tc.want {
+				t.Fatalf("nextLinkURL(%q, %q) = %q, want %q", tc.endpoint, tc.link, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCosignTag(t *testing.T) {
+	cases := []struct {
+		name   string
+		digest string
+		suffix string
+		want   string
+	}{
+		{name: "signature", digest: "sha256:abcd1234", suffix: ".sig", want: "sha256-abcd1234.sig"},
+		{name: "attestation", digest: "sha256:abcd1234", suffix: ".att", want: "sha256-abcd1234.att"},
+		{name: "sbom", digest: "sha256:abcd1234", suffix: ".sbom", want: "sha256-abcd1234.sbom"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cosignTag(tc.digest, tc.suffix); got != tc.want {
+				t.Fatalf("cosignTag(%q, %q) = %q, want %q", tc.digest, tc.suffix, got, tc.want)
+			}
+		})
+	}
+}
+
+// registryRefFixture 在测试用的 httptest server 上构造一个 registryRef，
+// Endpoint 指向 server 自己的 host:port(server.URL 去掉 "https://" 前缀)
+func registryRefFixture(server *httptest.Server, repository, tag string) registryRef {
+	return registryRef{
+		Endpoint:   strings.TrimPrefix(server.URL, "https://"),
+		Repository: repository,
+		Tag:        tag,
+	}
+}
+
+// newTestRegistryClient 构造一个指向 server 的 registryClient，
+// 凭证解析链留空，测试 server 不需要任何认证
+func newTestRegistryClient(server *httptest.Server) *registryClient {
+	return &registryClient{httpCli: server.Client(), dest: Destination{}, resolver: credentials.ChainResolver{}}
+}
+
+// TestCopyManifestRefMountAndFallback 覆盖 copyManifestRef 对单架构镜像的搬运：
+// config blob 走 cross-repo mount 成功，layer blob 被注册表拒绝挂载后
+// 退回到拉取+上传
+func TestCopyManifestRefMountAndFallback(t *testing.T) {
+	m := manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Config:        descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Digest: "sha256:config1", Size: 10},
+		Layers:        []descriptor{{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Digest: "sha256:layer1", Size: int64(len("layer-bytes"))}},
+	}
+	rawManifest, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("marshal fixture manifest: %v", err)
+	}
+
+	var mountedDigests, copiedDigests, putManifestCalls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/src/repo/manifests/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", m.MediaType)
+		_, _ = w.Write(rawManifest)
+	})
+	mux.HandleFunc("/v2/dst/repo/blobs/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		if mount := r.URL.Query().Get("mount"); mount != "" {
+			mountedDigests = append(mountedDigests, mount)
+			if mount == "sha256:config1" {
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			// 注册表拒绝挂载，按协议退回成一次普通上传会话
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Location", "https://"+r.Host+"/v2/dst/repo/blobs/uploads/session1")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v2/src/repo/blobs/sha256:layer1", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("layer-bytes"))
+	})
+	mux.HandleFunc("/v2/dst/repo/blobs/uploads/session1", func(w http.ResponseWriter, r *http.Request) {
+		copiedDigests = append(copiedDigests, r.URL.Query().Get("digest"))
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "layer-bytes" {
+			t.Errorf("uploaded blob body = %q, want %q", body, "layer-bytes")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/v2/dst/repo/manifests/v1", func(w http.ResponseWriter, r *http.Request) {
+		putManifestCalls = append(putManifestCalls, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	client := newTestRegistryClient(server)
+	srcRef := registryRefFixture(server, "src/repo", "v1")
+	dstRef := registryRefFixture(server, "dst/repo", "v1")
+
+	raw, mediaType, err := copyManifestRef(client, srcRef, dstRef)
+	if err != nil {
+		t.Fatalf("copyManifestRef() unexpected error: %v", err)
+	}
+	if mediaType != m.MediaType {
+		t.Fatalf("mediaType = %q, want %q", mediaType, m.MediaType)
+	}
+	if string(raw) != string(rawManifest) {
+		t.Fatalf("raw manifest mismatch")
+	}
+	if len(mountedDigests) != 2 || mountedDigests[0] != "sha256:config1" || mountedDigests[1] != "sha256:layer1" {
+		t.Fatalf("mount attempts = %v, want [sha256:config1 sha256:layer1]", mountedDigests)
+	}
+	if len(copiedDigests) != 1 || copiedDigests[0] != "sha256:layer1" {
+		t.Fatalf("fallback-copied digests = %v, want [sha256:layer1]", copiedDigests)
+	}
+	if len(putManifestCalls) != 1 {
+		t.Fatalf("putManifest called %d times, want 1", len(putManifestCalls))
+	}
+}
+
+// TestCopyManifestRefManifestList 覆盖 manifest list / OCI index 的递归搬运：
+// 顶层索引只有一个子 manifest，子 manifest 的 blob 全部走 mount 成功
+func TestCopyManifestRefManifestList(t *testing.T) {
+	subManifest := manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Config:        descriptor{Digest: "sha256:subconfig", Size: 5},
+		Layers:        []descriptor{{Digest: "sha256:sublayer", Size: 6}},
+	}
+	rawSub, err := json.Marshal(subManifest)
+	if err != nil {
+		t.Fatalf("marshal sub manifest: %v", err)
+	}
+
+	index := manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.list.v2+json",
+		Manifests:     []descriptor{{MediaType: subManifest.MediaType, Digest: "sha256:sub", Size: int64(len(rawSub))}},
+	}
+	rawIndex, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("marshal index: %v", err)
+	}
+
+	var putManifestPaths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/src/repo/manifests/list-tag", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", index.MediaType)
+		_, _ = w.Write(rawIndex)
+	})
+	mux.HandleFunc("/v2/src/repo/manifests/sha256:sub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", subManifest.MediaType)
+		_, _ = w.Write(rawSub)
+	})
+	mux.HandleFunc("/v2/dst/repo/blobs/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		// 子 manifest 的全部 blob 都走 mount 成功，不需要退回到拉取+上传
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/v2/dst/repo/manifests/sha256:sub", func(w http.ResponseWriter, r *http.Request) {
+		putManifestPaths = append(putManifestPaths, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/v2/dst/repo/manifests/list-tag", func(w http.ResponseWriter, r *http.Request) {
+		putManifestPaths = append(putManifestPaths, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	client := newTestRegistryClient(server)
+	srcRef := registryRefFixture(server, "src/repo", "list-tag")
+	dstRef := registryRefFixture(server, "dst/repo", "list-tag")
+
+	raw, mediaType, err := copyManifestRef(client, srcRef, dstRef)
+	if err != nil {
+		t.Fatalf("copyManifestRef() unexpected error: %v", err)
+	}
+	if mediaType != index.MediaType {
+		t.Fatalf("mediaType = %q, want %q", mediaType, index.MediaType)
+	}
+	if string(raw) != string(rawIndex) {
+		t.Fatalf("raw manifest mismatch")
+	}
+	want := []string{"/v2/dst/repo/manifests/sha256:sub", "/v2/dst/repo/manifests/list-tag"}
+	if fmt.Sprint(putManifestPaths) != fmt.Sprint(want) {
+		t.Fatalf("putManifest call order = %v, want %v (sub manifest must be pushed before the index)", putManifestPaths, want)
+	}
+}